@@ -2,33 +2,98 @@ package runn
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/target"
 	"github.com/chromedp/chromedp"
 )
 
 const cdpNewKey = "new"
 
+const cdpUserDataDirPrefix = "user-data-dir:"
+
 const (
 	cdpTimeoutByStep = 60 * time.Second
 	cdpWindowWidth   = 1920
 	cdpWindowHeight  = 1080
 )
 
+const (
+	cdpDownloadPollInterval = 500 * time.Millisecond
+	cdpDownloadStartTimeout = 5 * time.Second
+	cdpDownloadEndTimeout   = 30 * time.Second
+)
+
 type cdpRunner struct {
 	name          string
 	ctx           context.Context
 	cancel        context.CancelFunc
 	store         map[string]any
 	operator      *operator
+	remote        string
 	opts          []chromedp.ExecAllocatorOption
 	timeoutByStep time.Duration
+	// tabStack holds the contexts that newTab/switchTab moved away from; closeTab pops it to
+	// restore them in LIFO order (switchTab only pushes onto it).
+	tabStack []context.Context
+
+	// networkMu guards the blockPatterns/interceptRules/harEntries state below, which is
+	// written from chromedp's own event-listener goroutine as well as the action loop.
+	networkMu          sync.Mutex
+	fetchEnabled       bool
+	harEnabled         bool
+	networkIdleEnabled bool
+	inflightRequests   int
+	blockPatterns      []*regexp.Regexp
+	interceptRules     []*cdpInterceptRule
+	harEntries         []*cdpHAREntry
+}
+
+// cdpInterceptRule stubs a matching fetch request with a canned response instead of letting
+// it reach the network.
+type cdpInterceptRule struct {
+	pattern *regexp.Regexp
+	status  int64
+	headers map[string]string
+	body    []byte
+}
+
+// cdpStorageCookie mirrors the JSON shape network.Cookie exports, kept separate from
+// network.CookieParam so importStorageState can apply its own session/expiry handling
+// instead of relying on a direct JSON round-trip between the two CDP types.
+type cdpStorageCookie struct {
+	Name     string                 `json:"name"`
+	Value    string                 `json:"value"`
+	Domain   string                 `json:"domain"`
+	Path     string                 `json:"path"`
+	Expires  float64                `json:"expires"`
+	HTTPOnly bool                   `json:"httpOnly"`
+	Secure   bool                   `json:"secure"`
+	SameSite network.CookieSameSite `json:"sameSite"`
+}
+
+// cdpHAREntry is the subset of a request/response pair captureHAR needs to emit a HAR 1.2 entry.
+type cdpHAREntry struct {
+	requestID network.RequestID
+	method    string
+	url       string
+	status    int64
+	startedAt time.Time
 }
 
 type CDPActions []CDPAction
@@ -39,14 +104,38 @@ type CDPAction struct {
 }
 
 func newCDPRunner(name, remote string) (*cdpRunner, error) {
-	if remote != cdpNewKey {
-		return nil, errors.New("remote connect mode is planned, but not yet implemented")
+	// remote == "new": launch a fresh, isolated Chrome (the default, existing behavior).
+	// remote == "ws://..." or "wss://...": attach to an already-running Chrome via its
+	// DevTools websocket endpoint instead of launching one.
+	// remote == "user-data-dir:<path>": launch a fresh Chrome that reuses a persistent
+	// profile directory, so cookies/localStorage survive across runs.
+	if strings.HasPrefix(remote, "ws://") || strings.HasPrefix(remote, "wss://") {
+		allocCtx, cancel := chromedp.NewRemoteAllocator(context.Background(), remote)
+		ctx, _ := chromedp.NewContext(allocCtx)
+		return &cdpRunner{
+			name:          name,
+			ctx:           ctx,
+			cancel:        cancel,
+			store:         map[string]any{},
+			remote:        remote,
+			timeoutByStep: cdpTimeoutByStep,
+		}, nil
 	}
 
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
 		chromedp.WindowSize(cdpWindowWidth, cdpWindowHeight),
 	)
 
+	if strings.HasPrefix(remote, cdpUserDataDirPrefix) {
+		dir := strings.TrimPrefix(remote, cdpUserDataDirPrefix)
+		if dir == "" {
+			return nil, errors.New("user-data-dir: requires a path")
+		}
+		opts = append(opts, chromedp.UserDataDir(dir))
+	} else if remote != cdpNewKey {
+		return nil, fmt.Errorf("invalid remote: %s", remote)
+	}
+
 	if os.Getenv("RUNN_DISABLE_HEADLESS") != "" {
 		opts = append(opts,
 			chromedp.Flag("headless", false),
@@ -62,6 +151,7 @@ func newCDPRunner(name, remote string) (*cdpRunner, error) {
 		ctx:           ctx,
 		cancel:        cancel,
 		store:         map[string]any{},
+		remote:        remote,
 		opts:          opts,
 		timeoutByStep: cdpTimeoutByStep,
 	}, nil
@@ -80,7 +170,23 @@ func (rnr *cdpRunner) Renew() error {
 	if err := rnr.Close(); err != nil {
 		return err
 	}
-	allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), rnr.opts...)
+	var (
+		allocCtx context.Context
+		cancel   context.CancelFunc
+	)
+	if strings.HasPrefix(rnr.remote, "ws://") || strings.HasPrefix(rnr.remote, "wss://") {
+		allocCtx, cancel = chromedp.NewRemoteAllocator(context.Background(), rnr.remote)
+	} else {
+		allocCtx, cancel = chromedp.NewExecAllocator(context.Background(), rnr.opts...)
+	}
+	// The new browser has no Fetch/Network domains enabled and none of the old tabs, so
+	// drop the per-browser state that would otherwise stop them from being set up again.
+	rnr.networkMu.Lock()
+	rnr.fetchEnabled, rnr.harEnabled, rnr.networkIdleEnabled = false, false, false
+	rnr.inflightRequests = 0
+	rnr.blockPatterns, rnr.interceptRules, rnr.harEntries = nil, nil, nil
+	rnr.networkMu.Unlock()
+	rnr.tabStack = nil
 	ctx, _ := chromedp.NewContext(allocCtx)
 	rnr.ctx = ctx
 	rnr.cancel = cancel
@@ -111,6 +217,19 @@ func (rnr *cdpRunner) Run(_ context.Context, cas CDPActions) error {
 	if err := chromedp.Run(rnr.ctx, before...); err != nil {
 		return err
 	}
+
+	// Enable HAR recording before the first action runs (rather than when the captureHAR
+	// action itself is reached) so it actually has every request/response of the run to
+	// report, not just whatever arrives after that action.
+	for _, ca := range cas {
+		if ca.Fn == "captureHAR" {
+			if err := rnr.enableHARCapture(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
 	for i, ca := range cas {
 		rnr.operator.capturers.captureCDPAction(ca)
 		k, fn, err := findCDPFn(ca.Fn)
@@ -120,17 +239,183 @@ func (rnr *cdpRunner) Run(_ context.Context, cas CDPActions) error {
 		if k == "latestTab" {
 			infos, err := chromedp.Targets(rnr.ctx)
 			if err != nil {
+				rnr.captureFailureArtifacts(i)
 				return err
 			}
 			latestCtx, _ := chromedp.NewContext(rnr.ctx, chromedp.WithTargetID(infos[0].TargetID))
 			rnr.ctx = latestCtx
 			continue
 		}
+		if k == "download" {
+			path, b, err := rnr.runDownload(ca)
+			if err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			if ras := fn.Args.ResArgs(); len(ras) > 0 {
+				rnr.store[ras[0].Key] = map[string]any{"path": path, "body": b}
+			}
+			continue
+		}
+		if k == "newTab" {
+			url, _ := ca.Args["url"].(string)
+			if err := rnr.newTab(url); err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			continue
+		}
+		if k == "switchTab" {
+			if err := rnr.switchTab(ca.Args); err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			continue
+		}
+		if k == "closeTab" {
+			if err := rnr.closeTab(); err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			continue
+		}
+		if k == "listTabs" {
+			tabs, err := rnr.listTabs()
+			if err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			if ras := fn.Args.ResArgs(); len(ras) > 0 {
+				rnr.store[ras[0].Key] = tabs
+			}
+			continue
+		}
+		if k == "blockURLs" {
+			if err := rnr.blockURLs(ca.Args); err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			continue
+		}
+		if k == "interceptRequest" {
+			if err := rnr.interceptRequest(ca.Args); err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			continue
+		}
+		if k == "captureHAR" {
+			// Capture is already running (enabled above, before the action loop); this
+			// just flushes what has accumulated so far into a HAR document.
+			if ras := fn.Args.ResArgs(); len(ras) > 0 {
+				rnr.store[ras[0].Key] = rnr.buildHAR()
+			}
+			continue
+		}
+		if k == "waitForSelector" {
+			if err := rnr.waitForSelector(ca.Args); err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			continue
+		}
+		if k == "waitForFunction" {
+			if err := rnr.waitForFunction(ca.Args); err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			continue
+		}
+		if k == "waitForNetworkIdle" {
+			if err := rnr.waitForNetworkIdle(ca.Args); err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			continue
+		}
+		if k == "waitForDownload" {
+			b, err := rnr.waitForDownload(ca.Args)
+			if err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			if ras := fn.Args.ResArgs(); len(ras) > 0 {
+				rnr.store[ras[0].Key] = b
+			}
+			continue
+		}
+		if k == "screenshot" {
+			b, err := rnr.screenshot()
+			if err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			if name, ok := ca.Args["name"].(string); ok {
+				rnr.operator.capturers.captureCDPArtifact(name, "png", b)
+			}
+			if ras := fn.Args.ResArgs(); len(ras) > 0 {
+				rnr.store[ras[0].Key] = b
+			}
+			continue
+		}
+		if k == "pdf" {
+			b, err := rnr.pdf()
+			if err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			if name, ok := ca.Args["name"].(string); ok {
+				rnr.operator.capturers.captureCDPArtifact(name, "pdf", b)
+			}
+			if ras := fn.Args.ResArgs(); len(ras) > 0 {
+				rnr.store[ras[0].Key] = b
+			}
+			continue
+		}
+		if k == "outerHTML" {
+			sel, ok := ca.Args["sel"].(string)
+			if !ok {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: invalid action: %v: arg 'sel' not found", i, ca)
+			}
+			html, err := rnr.outerHTML(sel)
+			if err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			if ras := fn.Args.ResArgs(); len(ras) > 0 {
+				rnr.store[ras[0].Key] = html
+			}
+			continue
+		}
+		if k == "exportStorageState" {
+			state, err := rnr.exportStorageState()
+			if err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			if ras := fn.Args.ResArgs(); len(ras) > 0 {
+				rnr.store[ras[0].Key] = state
+			}
+			continue
+		}
+		if k == "importStorageState" {
+			if err := rnr.importStorageState(ca.Args); err != nil {
+				rnr.captureFailureArtifacts(i)
+				return fmt.Errorf("actions[%d] error: %w", i, err)
+			}
+			continue
+		}
 		as, err := rnr.evalAction(ca)
 		if err != nil {
+			// evalAction fails on the action's shape (bad/missing arg, wrong type) before
+			// anything reaches the browser, so there is no new browser state tied to this
+			// error worth capturing here; captureFailureArtifacts runs once chromedp.Run
+			// below actually touches the page.
 			return fmt.Errorf("actions[%d] error: %w", i, err)
 		}
 		if err := chromedp.Run(rnr.ctx, as...); err != nil {
+			rnr.captureFailureArtifacts(i)
 			return fmt.Errorf("actions[%d] error: %w", i, err)
 		}
 		ras := fn.Args.ResArgs()
@@ -243,3 +528,779 @@ func (rnr *cdpRunner) evalAction(ca CDPAction) ([]chromedp.Action, error) {
 	}
 	return nil, fmt.Errorf("invalid action: %v", ca)
 }
+
+// newTab opens url (if non-empty) in a brand-new browser target and switches the runner onto
+// it, pushing the current context onto tabStack so closeTab can restore it later.
+func (rnr *cdpRunner) newTab(url string) error {
+	ctx, _ := chromedp.NewContext(rnr.ctx)
+	if url != "" {
+		if err := chromedp.Run(ctx, chromedp.Navigate(url)); err != nil {
+			return err
+		}
+	}
+	rnr.tabStack = append(rnr.tabStack, rnr.ctx)
+	rnr.ctx = ctx
+	return nil
+}
+
+// switchTab finds the target matching args ("index" into chromedp.Targets, or a regexp
+// "urlPattern"/"title") and moves the runner onto it, pushing the prior context onto
+// tabStack so a later closeTab can come back. switchTab itself never pops tabStack.
+func (rnr *cdpRunner) switchTab(args map[string]any) error {
+	infos, err := chromedp.Targets(rnr.ctx)
+	if err != nil {
+		return err
+	}
+	info, err := matchTab(infos, args)
+	if err != nil {
+		return err
+	}
+	ctx, _ := chromedp.NewContext(rnr.ctx, chromedp.WithTargetID(info.TargetID))
+	rnr.tabStack = append(rnr.tabStack, rnr.ctx)
+	rnr.ctx = ctx
+	return nil
+}
+
+// closeTab closes the current target and pops tabStack to restore the context that was
+// active before the newTab/switchTab that led here.
+func (rnr *cdpRunner) closeTab() error {
+	if len(rnr.tabStack) == 0 {
+		return errors.New("closeTab: no previous tab to return to")
+	}
+	c := chromedp.FromContext(rnr.ctx)
+	if c.Target == nil {
+		return errors.New("closeTab: current tab has not run any action yet")
+	}
+	if err := chromedp.Run(rnr.ctx, target.CloseTarget(c.Target.TargetID)); err != nil {
+		return err
+	}
+	rnr.ctx = rnr.tabStack[len(rnr.tabStack)-1]
+	rnr.tabStack = rnr.tabStack[:len(rnr.tabStack)-1]
+	return nil
+}
+
+// listTabs returns the title and URL of every open browser target.
+func (rnr *cdpRunner) listTabs() ([]map[string]string, error) {
+	infos, err := chromedp.Targets(rnr.ctx)
+	if err != nil {
+		return nil, err
+	}
+	tabs := make([]map[string]string, len(infos))
+	for i, info := range infos {
+		tabs[i] = map[string]string{"title": info.Title, "url": info.URL}
+	}
+	return tabs, nil
+}
+
+// cdpArgInt coerces a decoded action arg into an int. runn's loader yields uint64 for plain
+// YAML/JSON integers, but int/int64/float64 are accepted too so callers don't care which
+// decoder produced the value. ok is false if v isn't a number.
+func cdpArgInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	default:
+		return 0, false
+	}
+}
+
+// cdpArgDuration coerces args[key] (milliseconds, any numeric kind accepted by cdpArgInt)
+// into a time.Duration, falling back to def if the key is absent or not a number.
+func cdpArgDuration(args map[string]any, key string, def time.Duration) time.Duration {
+	if n, ok := cdpArgInt(args[key]); ok {
+		return time.Duration(n) * time.Millisecond
+	}
+	return def
+}
+
+// cdpArgStringMap coerces args[key] into map[string]string. Nested YAML/JSON maps decode as
+// map[string]any, never map[string]string, so a plain type assertion always fails.
+func cdpArgStringMap(v any) map[string]string {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, vv := range m {
+		if s, ok := vv.(string); ok {
+			res[k] = s
+		}
+	}
+	return res
+}
+
+// matchTab resolves a switchTab target from args: an "index" into infos, or a regexp
+// given as "urlPattern" or "title", matched against each target's URL or title.
+func matchTab(infos []*target.Info, args map[string]any) (*target.Info, error) {
+	if idx, ok := cdpArgInt(args["index"]); ok {
+		if idx < 0 || idx >= len(infos) {
+			return nil, fmt.Errorf("switchTab: index out of range: %d", idx)
+		}
+		return infos[idx], nil
+	}
+	if p, ok := args["urlPattern"].(string); ok {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if re.MatchString(info.URL) {
+				return info, nil
+			}
+		}
+		return nil, fmt.Errorf("switchTab: no tab matching urlPattern: %s", p)
+	}
+	if t, ok := args["title"].(string); ok {
+		re, err := regexp.Compile(t)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if re.MatchString(info.Title) {
+				return info, nil
+			}
+		}
+		return nil, fmt.Errorf("switchTab: no tab matching title: %s", t)
+	}
+	return nil, errors.New("switchTab: requires one of index, urlPattern, title")
+}
+
+// blockURLs compiles args["patterns"] as regexps and drops any future request whose URL
+// matches one of them, e.g. to silence analytics/CDN noise during a run.
+func (rnr *cdpRunner) blockURLs(args map[string]any) error {
+	raw, ok := args["patterns"].([]any)
+	if !ok {
+		return errors.New("invalid action: blockURLs: arg 'patterns' not found")
+	}
+	res := make([]*regexp.Regexp, 0, len(raw))
+	for _, p := range raw {
+		s, ok := p.(string)
+		if !ok {
+			return fmt.Errorf("invalid blockURLs pattern: %v", p)
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		res = append(res, re)
+	}
+	if err := rnr.enableFetchInterception(); err != nil {
+		return err
+	}
+	rnr.networkMu.Lock()
+	rnr.blockPatterns = append(rnr.blockPatterns, res...)
+	rnr.networkMu.Unlock()
+	return nil
+}
+
+// interceptRequest stubs any future request matching args["pattern"] with a canned
+// response built from args["status"]/"headers" and either args["body"] or the contents of
+// args["bodyFromFile"], instead of letting it reach the network.
+func (rnr *cdpRunner) interceptRequest(args map[string]any) error {
+	pattern, ok := args["pattern"].(string)
+	if !ok {
+		return errors.New("invalid action: interceptRequest: arg 'pattern' not found")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	status := int64(200)
+	if s, ok := cdpArgInt(args["status"]); ok {
+		status = int64(s)
+	}
+	headers := cdpArgStringMap(args["headers"])
+
+	var body []byte
+	switch {
+	case args["body"] != nil:
+		s, ok := args["body"].(string)
+		if !ok {
+			return fmt.Errorf("invalid interceptRequest body: %v", args["body"])
+		}
+		body = []byte(s)
+	case args["bodyFromFile"] != nil:
+		p, ok := args["bodyFromFile"].(string)
+		if !ok {
+			return fmt.Errorf("invalid interceptRequest bodyFromFile: %v", args["bodyFromFile"])
+		}
+		if !strings.HasPrefix(p, "/") {
+			p = filepath.Join(rnr.operator.root, p)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	if err := rnr.enableFetchInterception(); err != nil {
+		return err
+	}
+	rnr.networkMu.Lock()
+	rnr.interceptRules = append(rnr.interceptRules, &cdpInterceptRule{
+		pattern: re,
+		status:  status,
+		headers: headers,
+		body:    body,
+	})
+	rnr.networkMu.Unlock()
+	return nil
+}
+
+// enableFetchInterception enables the Fetch domain and installs a requestPaused listener
+// (once per runner) that aborts blocked requests, fulfills requests matching an
+// interceptRule, and otherwise lets everything else continue unmodified.
+func (rnr *cdpRunner) enableFetchInterception() error {
+	rnr.networkMu.Lock()
+	defer rnr.networkMu.Unlock()
+	if rnr.fetchEnabled {
+		return nil
+	}
+	if err := chromedp.Run(rnr.ctx, fetch.Enable()); err != nil {
+		return err
+	}
+	c := chromedp.FromContext(rnr.ctx)
+	chromedp.ListenTarget(rnr.ctx, func(ev any) {
+		e, ok := ev.(*fetch.EventRequestPaused)
+		if ok {
+			// chromedp processes CDP command replies on this same dispatch goroutine, so
+			// issuing the fetch.*Request command synchronously here would deadlock waiting
+			// on its own reply. Run it on its own goroutine against the target's executor.
+			go rnr.handlePausedRequest(c, e)
+		}
+	})
+	rnr.fetchEnabled = true
+	return nil
+}
+
+func (rnr *cdpRunner) handlePausedRequest(c *chromedp.Context, e *fetch.EventRequestPaused) {
+	ctx := cdp.WithExecutor(rnr.ctx, c.Target)
+
+	rnr.networkMu.Lock()
+	for _, p := range rnr.blockPatterns {
+		if p.MatchString(e.Request.URL) {
+			rnr.networkMu.Unlock()
+			_ = fetch.FailRequest(e.RequestID, network.ErrorReasonBlockedByClient).Do(ctx)
+			return
+		}
+	}
+	for _, rule := range rnr.interceptRules {
+		if rule.pattern.MatchString(e.Request.URL) {
+			rnr.networkMu.Unlock()
+			headers := make([]*fetch.HeaderEntry, 0, len(rule.headers))
+			for k, v := range rule.headers {
+				headers = append(headers, &fetch.HeaderEntry{Name: k, Value: v})
+			}
+			_ = fetch.FulfillRequest(e.RequestID, rule.status).
+				WithResponseHeaders(headers).
+				WithBody(base64.StdEncoding.EncodeToString(rule.body)).
+				Do(ctx)
+			return
+		}
+	}
+	rnr.networkMu.Unlock()
+	_ = fetch.ContinueRequest(e.RequestID).Do(ctx)
+}
+
+// enableHARCapture enables the Network domain and installs listeners (once per runner)
+// that record every request/response pair, so a later captureHAR action can emit them.
+func (rnr *cdpRunner) enableHARCapture() error {
+	rnr.networkMu.Lock()
+	defer rnr.networkMu.Unlock()
+	if rnr.harEnabled {
+		return nil
+	}
+	if err := chromedp.Run(rnr.ctx, network.Enable()); err != nil {
+		return err
+	}
+	chromedp.ListenTarget(rnr.ctx, func(ev any) {
+		switch e := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			rnr.networkMu.Lock()
+			rnr.harEntries = append(rnr.harEntries, &cdpHAREntry{
+				requestID: e.RequestID,
+				method:    e.Request.Method,
+				url:       e.Request.URL,
+				startedAt: e.WallTime.Time(),
+			})
+			rnr.networkMu.Unlock()
+		case *network.EventResponseReceived:
+			rnr.networkMu.Lock()
+			for _, entry := range rnr.harEntries {
+				if entry.requestID == e.RequestID {
+					entry.status = e.Response.Status
+					break
+				}
+			}
+			rnr.networkMu.Unlock()
+		}
+	})
+	rnr.harEnabled = true
+	return nil
+}
+
+// buildHAR renders the entries recorded so far as a minimal HAR-1.2 document.
+func (rnr *cdpRunner) buildHAR() map[string]any {
+	rnr.networkMu.Lock()
+	defer rnr.networkMu.Unlock()
+	entries := make([]map[string]any, len(rnr.harEntries))
+	for i, e := range rnr.harEntries {
+		entries[i] = map[string]any{
+			"startedDateTime": e.startedAt.Format(time.RFC3339Nano),
+			"request":         map[string]any{"method": e.method, "url": e.url},
+			"response":        map[string]any{"status": e.status},
+		}
+	}
+	return map[string]any{
+		"log": map[string]any{
+			"version": "1.2",
+			"creator": map[string]any{"name": "runn", "version": "cdp"},
+			"entries": entries,
+		},
+	}
+}
+
+// waitForSelector blocks until args["sel"] reaches args["state"] ("visible" (default),
+// "hidden", "attached", or "detached"), or args["timeout"] (milliseconds, default
+// cdpTimeoutByStep) elapses.
+func (rnr *cdpRunner) waitForSelector(args map[string]any) error {
+	sel, ok := args["sel"].(string)
+	if !ok {
+		return errors.New("invalid action: waitForSelector: arg 'sel' not found")
+	}
+	state, _ := args["state"].(string)
+	timeout := cdpArgDuration(args, "timeout", cdpTimeoutByStep)
+
+	var action chromedp.Action
+	switch state {
+	case "", "visible":
+		action = chromedp.WaitVisible(sel, chromedp.ByQuery)
+	case "hidden":
+		action = chromedp.WaitNotVisible(sel, chromedp.ByQuery)
+	case "attached":
+		action = chromedp.WaitReady(sel, chromedp.ByQuery)
+	case "detached":
+		action = chromedp.WaitNotPresent(sel, chromedp.ByQuery)
+	default:
+		return fmt.Errorf("invalid waitForSelector state: %s", state)
+	}
+
+	ctx, cancel := context.WithTimeout(rnr.ctx, timeout)
+	defer cancel()
+	return chromedp.Run(ctx, action)
+}
+
+// waitForFunction polls args["expr"] (a JS expression evaluated for truthiness) every
+// args["pollingMs"] (default 250ms) until it is true or args["timeout"] (default
+// cdpTimeoutByStep) elapses.
+func (rnr *cdpRunner) waitForFunction(args map[string]any) error {
+	expr, ok := args["expr"].(string)
+	if !ok {
+		return errors.New("invalid action: waitForFunction: arg 'expr' not found")
+	}
+	polling := cdpArgDuration(args, "pollingMs", 250*time.Millisecond)
+	timeout := cdpArgDuration(args, "timeout", cdpTimeoutByStep)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var truthy bool
+		if err := chromedp.Run(rnr.ctx, chromedp.Evaluate(expr, &truthy)); err != nil {
+			return err
+		}
+		if truthy {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("waitForFunction: timed out waiting for %q", expr)
+		}
+		time.Sleep(polling)
+	}
+}
+
+// enableNetworkIdleTracking enables the Network domain and installs listeners (once per
+// runner) that keep a running in-flight request count for waitForNetworkIdle.
+func (rnr *cdpRunner) enableNetworkIdleTracking() error {
+	rnr.networkMu.Lock()
+	defer rnr.networkMu.Unlock()
+	if rnr.networkIdleEnabled {
+		return nil
+	}
+	if err := chromedp.Run(rnr.ctx, network.Enable()); err != nil {
+		return err
+	}
+	chromedp.ListenTarget(rnr.ctx, func(ev any) {
+		switch ev.(type) {
+		case *network.EventRequestWillBeSent:
+			rnr.networkMu.Lock()
+			rnr.inflightRequests++
+			rnr.networkMu.Unlock()
+		case *network.EventLoadingFinished, *network.EventLoadingFailed:
+			rnr.networkMu.Lock()
+			if rnr.inflightRequests > 0 {
+				rnr.inflightRequests--
+			}
+			rnr.networkMu.Unlock()
+		}
+	})
+	rnr.networkIdleEnabled = true
+	return nil
+}
+
+// waitForNetworkIdle blocks until the in-flight request count is zero both before and after
+// an args["idleMs"] (default 500ms) wait, or returns an error once args["timeout"] (default
+// cdpTimeoutByStep) elapses first. A request that starts and finishes within that wait is
+// not noticed.
+func (rnr *cdpRunner) waitForNetworkIdle(args map[string]any) error {
+	idle := cdpArgDuration(args, "idleMs", 500*time.Millisecond)
+	timeout := cdpArgDuration(args, "timeout", cdpTimeoutByStep)
+	if err := rnr.enableNetworkIdleTracking(); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		rnr.networkMu.Lock()
+		n := rnr.inflightRequests
+		rnr.networkMu.Unlock()
+		if n == 0 {
+			time.Sleep(idle)
+			rnr.networkMu.Lock()
+			stillIdle := rnr.inflightRequests == 0
+			rnr.networkMu.Unlock()
+			if stillIdle {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return errors.New("waitForNetworkIdle: timed out")
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return errors.New("waitForNetworkIdle: timed out")
+		}
+		time.Sleep(cdpDownloadPollInterval)
+	}
+}
+
+// waitForDownload waits for a file to arrive in args["dir"] (resolved relative to
+// rnr.operator.root), using the same start/settle polling as the download action, and
+// returns its contents. Unlike the download action it does not dispatch a trigger itself.
+func (rnr *cdpRunner) waitForDownload(args map[string]any) ([]byte, error) {
+	dir, ok := args["dir"].(string)
+	if !ok {
+		return nil, errors.New("invalid action: waitForDownload: arg 'dir' not found")
+	}
+	if !strings.HasPrefix(dir, "/") {
+		dir = filepath.Join(rnr.operator.root, dir)
+	}
+	timeout := cdpArgDuration(args, "timeout", cdpDownloadEndTimeout)
+
+	name, err := waitForDownloadFile(dir, timeout, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(dir, name))
+}
+
+// captureFailureArtifacts grabs a full-page screenshot, the current URL, and the page's
+// outer HTML right after an action-loop error, handing them to
+// capturers.captureCDPFailure so a failed run can be inspected after the fact. Each grab is
+// best-effort: a failure here must never mask the original action error.
+func (rnr *cdpRunner) captureFailureArtifacts(step int) {
+	var (
+		png  []byte
+		url  string
+		html string
+	)
+	_ = chromedp.Run(rnr.ctx, chromedp.FullScreenshot(&png, 90))
+	_ = chromedp.Run(rnr.ctx, chromedp.Location(&url))
+	_ = chromedp.Run(rnr.ctx, chromedp.OuterHTML("html", &html, chromedp.ByQuery))
+	rnr.operator.capturers.captureCDPFailure(rnr.name, step, map[string]any{
+		"screenshot": png,
+		"url":        url,
+		"html":       html,
+	})
+}
+
+// screenshot captures the full scrollable page as a PNG.
+func (rnr *cdpRunner) screenshot() ([]byte, error) {
+	var b []byte
+	if err := chromedp.Run(rnr.ctx, chromedp.FullScreenshot(&b, 90)); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// pdf renders the current page to PDF via page.PrintToPDF.
+func (rnr *cdpRunner) pdf() ([]byte, error) {
+	var b []byte
+	if err := chromedp.Run(rnr.ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		buf, _, err := page.PrintToPDF().Do(ctx)
+		if err != nil {
+			return err
+		}
+		b = buf
+		return nil
+	})); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// outerHTML returns the outerHTML of the element matching sel.
+func (rnr *cdpRunner) outerHTML(sel string) (string, error) {
+	var html string
+	if err := chromedp.Run(rnr.ctx, chromedp.OuterHTML(sel, &html, chromedp.ByQuery)); err != nil {
+		return "", err
+	}
+	return html, nil
+}
+
+// exportStorageState snapshots cookies (all origins, same as network.GetAllCookies) and the
+// current page's localStorage/sessionStorage into a single JSON-serializable blob, following
+// Playwright's storageState shape (with an added sessionStorage entry). Unlike Playwright,
+// which walks every known origin, this only captures one "origins" entry for the page's
+// current origin, so importing this export restores localStorage/sessionStorage for that
+// one origin only.
+func (rnr *cdpRunner) exportStorageState() (map[string]any, error) {
+	var cookies []*network.Cookie
+	if err := chromedp.Run(rnr.ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		cs, err := network.GetAllCookies().Do(ctx)
+		if err != nil {
+			return err
+		}
+		cookies = cs
+		return nil
+	})); err != nil {
+		return nil, err
+	}
+
+	var origin string
+	if err := chromedp.Run(rnr.ctx, chromedp.Evaluate(`window.location.origin`, &origin)); err != nil {
+		return nil, err
+	}
+	localStorage, err := rnr.dumpStorage("localStorage")
+	if err != nil {
+		return nil, err
+	}
+	sessionStorage, err := rnr.dumpStorage("sessionStorage")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"cookies": cookies,
+		"origins": []map[string]any{
+			{
+				"origin":         origin,
+				"localStorage":   localStorage,
+				"sessionStorage": sessionStorage,
+			},
+		},
+	}, nil
+}
+
+// dumpStorage reads every key/value pair out of window[kind] (localStorage or
+// sessionStorage) as a Playwright-style []{name, value} list.
+func (rnr *cdpRunner) dumpStorage(kind string) ([]map[string]string, error) {
+	expr := fmt.Sprintf(`Object.keys(window.%s).map(k => ({name: k, value: window.%s.getItem(k)}))`, kind, kind)
+	var items []map[string]string
+	if err := chromedp.Run(rnr.ctx, chromedp.Evaluate(expr, &items)); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// importStorageState restores cookies and per-origin localStorage/sessionStorage from a
+// blob produced by exportStorageState, read from args["path"] (resolved relative to
+// rnr.operator.root) or given directly as args["inline"] JSON.
+func (rnr *cdpRunner) importStorageState(args map[string]any) error {
+	var raw []byte
+	switch {
+	case args["inline"] != nil:
+		s, ok := args["inline"].(string)
+		if !ok {
+			return fmt.Errorf("invalid importStorageState inline: %v", args["inline"])
+		}
+		raw = []byte(s)
+	case args["path"] != nil:
+		p, ok := args["path"].(string)
+		if !ok {
+			return fmt.Errorf("invalid importStorageState path: %v", args["path"])
+		}
+		if !strings.HasPrefix(p, "/") {
+			p = filepath.Join(rnr.operator.root, p)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		raw = b
+	default:
+		return errors.New("invalid action: importStorageState: requires 'path' or 'inline'")
+	}
+
+	var state struct {
+		Cookies []cdpStorageCookie `json:"cookies"`
+		Origins []struct {
+			Origin         string              `json:"origin"`
+			LocalStorage   []map[string]string `json:"localStorage"`
+			SessionStorage []map[string]string `json:"sessionStorage"`
+		} `json:"origins"`
+	}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return err
+	}
+
+	if len(state.Cookies) > 0 {
+		params := make([]*network.CookieParam, len(state.Cookies))
+		for i, c := range state.Cookies {
+			p := &network.CookieParam{
+				Name:     c.Name,
+				Value:    c.Value,
+				Domain:   c.Domain,
+				Path:     c.Path,
+				HTTPOnly: c.HTTPOnly,
+				Secure:   c.Secure,
+				SameSite: c.SameSite,
+			}
+			// network.Cookie.Expires is -1 (or any non-positive value) for a session
+			// cookie. Leaving CookieParam.Expires at its zero value keeps it a session
+			// cookie; setting it from a non-positive value would give Chrome an
+			// already-past expiry and the cookie would be dropped immediately.
+			if c.Expires > 0 {
+				p.Expires = cdp.TimeSinceEpoch(time.Unix(int64(c.Expires), 0))
+			}
+			params[i] = p
+		}
+		if err := chromedp.Run(rnr.ctx, network.SetCookies(params)); err != nil {
+			return err
+		}
+	}
+	for _, o := range state.Origins {
+		if o.Origin != "" {
+			if err := chromedp.Run(rnr.ctx, chromedp.Navigate(o.Origin)); err != nil {
+				return err
+			}
+		}
+		if err := rnr.restoreStorage("localStorage", o.LocalStorage); err != nil {
+			return err
+		}
+		if err := rnr.restoreStorage("sessionStorage", o.SessionStorage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreStorage replays [{name, value}] items into window[kind] via Runtime.evaluate.
+func (rnr *cdpRunner) restoreStorage(kind string, items []map[string]string) error {
+	for _, item := range items {
+		expr := fmt.Sprintf(`window.%s.setItem(%q, %q)`, kind, item["name"], item["value"])
+		if err := chromedp.Run(rnr.ctx, chromedp.Evaluate(expr, nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runDownload points the browser's download behavior at a fresh per-step temp directory,
+// dispatches the click on ca.Args["sel"] that is expected to trigger a download, waits for
+// the resulting file to fully arrive, then moves it under rnr.operator.root so its path
+// stays valid (and readable by subsequent steps) after the temp directory is cleaned up.
+func (rnr *cdpRunner) runDownload(ca CDPAction) (string, []byte, error) {
+	sel, ok := ca.Args["sel"].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid action: %v: arg '%s' not found", ca, "sel")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "runn-cdp-download-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	as := []chromedp.Action{
+		browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllow).WithDownloadPath(tmpDir).WithEventsEnabled(true),
+		chromedp.Click(sel, chromedp.ByQuery),
+	}
+	if err := chromedp.Run(rnr.ctx, as...); err != nil {
+		return "", nil, err
+	}
+
+	name, err := waitForDownloadFile(tmpDir, cdpDownloadStartTimeout, cdpDownloadEndTimeout)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid action: %v: %w", ca, err)
+	}
+	b, err := os.ReadFile(filepath.Join(tmpDir, name))
+	if err != nil {
+		return "", nil, err
+	}
+
+	downloadsDir := filepath.Join(rnr.operator.root, "downloads")
+	if err := os.MkdirAll(downloadsDir, 0o755); err != nil {
+		return "", nil, err
+	}
+	destDir, err := os.MkdirTemp(downloadsDir, "")
+	if err != nil {
+		return "", nil, err
+	}
+	dest := filepath.Join(destDir, name)
+	if err := os.WriteFile(dest, b, 0o644); err != nil {
+		return "", nil, err
+	}
+	return dest, b, nil
+}
+
+// waitForDownloadFile polls dir every cdpDownloadPollInterval: startTimeout bounds how long
+// it waits for any entry to appear at all, endTimeout bounds how long it waits for that
+// entry's size to stop changing once it has appeared. Chrome keeps writing the in-progress
+// file under a ".crdownload" suffix, so such entries are treated as not yet arrived.
+func waitForDownloadFile(dir string, startTimeout, endTimeout time.Duration) (string, error) {
+	start := time.Now()
+	var name string
+	for name == "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", err
+		}
+		for _, e := range entries {
+			if !strings.HasSuffix(e.Name(), ".crdownload") {
+				name = e.Name()
+				break
+			}
+		}
+		if name != "" {
+			break
+		}
+		if time.Since(start) > startTimeout {
+			return "", errors.New("timed out waiting for download to start")
+		}
+		time.Sleep(cdpDownloadPollInterval)
+	}
+
+	deadline := time.Now().Add(endTimeout)
+	lastSize := int64(-1)
+	for {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		if fi.Size() == lastSize {
+			return name, nil
+		}
+		lastSize = fi.Size()
+		if time.Now().After(deadline) {
+			return "", errors.New("timed out waiting for download to finish")
+		}
+		time.Sleep(cdpDownloadPollInterval)
+	}
+}